@@ -0,0 +1,162 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// authChallenge holds the parsed parameters of a "WWW-Authenticate: Bearer
+// ..." challenge returned by a v2 registry.
+type authChallenge struct {
+	realm   string
+	service string
+	scope   string
+}
+
+// parseBearerChallenge parses the WWW-Authenticate header of a 401 response
+// into an authChallenge. It returns ok=false if the header isn't a Bearer
+// challenge.
+func parseBearerChallenge(header string) (challenge authChallenge, ok bool) {
+	if !strings.HasPrefix(header, "Bearer ") {
+		return authChallenge{}, false
+	}
+
+	for _, pair := range strings.Split(strings.TrimPrefix(header, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		val := strings.Trim(kv[1], `"`)
+		switch kv[0] {
+		case "realm":
+			challenge.realm = val
+		case "service":
+			challenge.service = val
+		case "scope":
+			challenge.scope = val
+		}
+	}
+
+	return challenge, challenge.realm != ""
+}
+
+// bearerToken exchanges an auth challenge for a bearer token, authenticating
+// with the given basic credentials (either of which may be empty for
+// anonymous pulls).
+func (c *registryClient) bearerToken(challenge authChallenge, username, password string) (string, error) {
+	req, err := http.NewRequest("GET", challenge.realm, nil)
+	if err != nil {
+		return "", err
+	}
+
+	q := req.URL.Query()
+	if challenge.service != "" {
+		q.Set("service", challenge.service)
+	}
+	if challenge.scope != "" {
+		q.Set("scope", challenge.scope)
+	}
+	req.URL.RawQuery = q.Encode()
+
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s failed: %s", challenge.realm, resp.Status)
+	}
+
+	var tokenResp struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", fmt.Errorf("decoding token response from %s: %v", challenge.realm, err)
+	}
+
+	if tokenResp.Token != "" {
+		return tokenResp.Token, nil
+	}
+	return tokenResp.AccessToken, nil
+}
+
+// authenticate performs the request and, if the registry challenges for
+// Bearer auth, retries it once with a token obtained for that challenge.
+func (c *registryClient) authenticate(req *http.Request) (*http.Response, error) {
+	c.setCredentials(req)
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge, ok := parseBearerChallenge(resp.Header.Get("WWW-Authenticate"))
+	if !ok {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	token, err := c.bearerToken(challenge, c.username, c.password)
+	if err != nil {
+		return nil, fmt.Errorf("authenticating with %s: %v", req.URL.Host, err)
+	}
+
+	retry, err := http.NewRequest(req.Method, req.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	retry.Header = req.Header
+	retry.Header.Set("Authorization", "Bearer "+token)
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("rewinding request body for retry: %v", err)
+		}
+		retry.Body = body
+		retry.ContentLength = req.ContentLength
+	} else if req.Body != nil && req.Body != http.NoBody {
+		return nil, fmt.Errorf("cannot retry %s %s after a 401: request body isn't replayable", req.Method, req.URL)
+	}
+
+	return c.httpClient().Do(retry)
+}
+
+// setCredentials attaches whatever static credentials the fetcher was
+// configured with to req, preferring an identity token (sent as-is as a
+// bearer token) over basic auth.
+func (c *registryClient) setCredentials(req *http.Request) {
+	if c.identityToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.identityToken)
+		return
+	}
+	if c.username != "" || c.password != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
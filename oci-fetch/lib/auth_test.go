@@ -0,0 +1,49 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import "testing"
+
+func TestParseBearerChallenge(t *testing.T) {
+	header := `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:library/busybox:pull"`
+
+	challenge, ok := parseBearerChallenge(header)
+	if !ok {
+		t.Fatalf("parseBearerChallenge(%q) returned ok=false", header)
+	}
+
+	want := authChallenge{
+		realm:   "https://auth.example.com/token",
+		service: "registry.example.com",
+		scope:   "repository:library/busybox:pull",
+	}
+	if challenge != want {
+		t.Errorf("parseBearerChallenge(%q) = %+v, want %+v", header, challenge, want)
+	}
+}
+
+func TestParseBearerChallengeNotBearer(t *testing.T) {
+	tests := []string{
+		`Basic realm="registry"`,
+		"",
+		`Bearer service="registry.example.com"`, // no realm
+	}
+
+	for _, header := range tests {
+		if _, ok := parseBearerChallenge(header); ok {
+			t.Errorf("parseBearerChallenge(%q) returned ok=true, want false", header)
+		}
+	}
+}
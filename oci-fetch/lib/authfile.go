@@ -0,0 +1,184 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// dockerAuthEntry is a single entry in a Docker config.json's "auths" map.
+type dockerAuthEntry struct {
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// dockerConfig is the subset of the Docker config.json schema that holds
+// credentials, as written by "docker login"/"podman login".
+type dockerConfig struct {
+	Auths       map[string]dockerAuthEntry `json:"auths"`
+	CredHelpers map[string]string          `json:"credHelpers"`
+	CredsStore  string                     `json:"credsStore"`
+}
+
+// SetAuthfile loads credentials from the Docker/Podman-style config.json at
+// path, to be consulted by Fetch for registries that weren't given explicit
+// --username/--password. Pass an empty path to fall back to the
+// REGISTRY_AUTH_FILE and DOCKER_CONFIG environment variables and finally
+// ~/.docker/config.json, in that order.
+func (c *registryClient) SetAuthfile(path string) error {
+	if path == "" {
+		path = defaultAuthfilePath()
+	}
+	if path == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading authfile %s: %v", path, err)
+	}
+
+	var cfg dockerConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("parsing authfile %s: %v", path, err)
+	}
+
+	c.authConfig = &cfg
+	return nil
+}
+
+// defaultAuthfilePath resolves the Docker config.json to use when none was
+// given explicitly.
+func defaultAuthfilePath() string {
+	if p := os.Getenv("REGISTRY_AUTH_FILE"); p != "" {
+		return p
+	}
+	if d := os.Getenv("DOCKER_CONFIG"); d != "" {
+		return filepath.Join(d, "config.json")
+	}
+	if u, err := user.Current(); err == nil {
+		return filepath.Join(u.HomeDir, ".docker", "config.json")
+	}
+	return ""
+}
+
+// resolveCredentials fills in username/password/identityToken for host from
+// the loaded authfile, if the fetcher wasn't already given explicit
+// credentials.
+func (c *registryClient) resolveCredentials(host string) error {
+	if c.authConfig == nil {
+		return nil
+	}
+	if c.username != "" || c.password != "" || c.identityToken != "" {
+		return nil
+	}
+
+	if helper := credHelperFor(c.authConfig, host); helper != "" {
+		username, secret, err := execCredHelper(helper, host)
+		switch {
+		case err == nil:
+			c.username = username
+			c.password = secret
+			return nil
+		case errors.Is(err, errCredHelperNotFound):
+			// The helper has nothing stored for host (e.g. a global
+			// credsStore from Docker Desktop with no login for this
+			// registry): fall through as if no authfile entry existed,
+			// rather than failing an otherwise-anonymous pull.
+		default:
+			return fmt.Errorf("resolving credentials for %s via docker-credential-%s: %v", host, helper, err)
+		}
+	}
+
+	entry, ok := c.authConfig.Auths[host]
+	if !ok {
+		return nil
+	}
+
+	if entry.IdentityToken != "" {
+		c.identityToken = entry.IdentityToken
+		return nil
+	}
+
+	if entry.Auth == "" {
+		return nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return fmt.Errorf("decoding auth entry for %s: %v", host, err)
+	}
+	userpass := strings.SplitN(string(decoded), ":", 2)
+	c.username = userpass[0]
+	if len(userpass) == 2 {
+		c.password = userpass[1]
+	}
+
+	return nil
+}
+
+// credHelperFor returns the name of the credential helper to use for host,
+// from either a per-registry credHelpers entry or the global credsStore.
+func credHelperFor(cfg *dockerConfig, host string) string {
+	if helper, ok := cfg.CredHelpers[host]; ok {
+		return helper
+	}
+	return cfg.CredsStore
+}
+
+// errCredHelperNotFound is returned by execCredHelper when the helper ran
+// successfully but has no credentials stored for the requested host, as
+// opposed to a real failure to run it.
+var errCredHelperNotFound = errors.New("credential helper has no credentials for this host")
+
+// execCredHelper runs "docker-credential-<helper> get", passing host on
+// stdin, and parses the returned {"Username","Secret"} JSON.
+func execCredHelper(helper, host string) (username, secret string, err error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(host)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(strings.ToLower(stderr.String()), "credentials not found") {
+			return "", "", errCredHelperNotFound
+		}
+		return "", "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", fmt.Errorf("parsing docker-credential-%s output: %v", helper, err)
+	}
+
+	return creds.Username, creds.Secret, nil
+}
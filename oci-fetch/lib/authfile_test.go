@@ -0,0 +1,72 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// installFakeCredHelper writes a "docker-credential-fakehelper" script onto
+// PATH (for the duration of the test) that returns stored credentials for
+// "registry.example.com" and otherwise reports, like a real helper, that it
+// has nothing for the requested host.
+func installFakeCredHelper(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := `#!/bin/sh
+read -r host
+if [ "$host" = "registry.example.com" ]; then
+  echo '{"Username":"bob","Secret":"hunter2"}'
+  exit 0
+fi
+echo "credentials not found in native keychain" >&2
+exit 1
+`
+	path := filepath.Join(dir, "docker-credential-fakehelper")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+	t.Cleanup(func() { os.Setenv("PATH", oldPath) })
+}
+
+func TestResolveCredentialsViaHelper(t *testing.T) {
+	installFakeCredHelper(t)
+
+	c := &registryClient{authConfig: &dockerConfig{CredsStore: "fakehelper"}}
+	if err := c.resolveCredentials("registry.example.com"); err != nil {
+		t.Fatalf("resolveCredentials: %v", err)
+	}
+	if c.username != "bob" || c.password != "hunter2" {
+		t.Errorf("resolveCredentials set username=%q password=%q, want bob/hunter2", c.username, c.password)
+	}
+}
+
+func TestResolveCredentialsHelperMissFallsThrough(t *testing.T) {
+	installFakeCredHelper(t)
+
+	c := &registryClient{authConfig: &dockerConfig{CredsStore: "fakehelper"}}
+	if err := c.resolveCredentials("other.example.com"); err != nil {
+		t.Fatalf("resolveCredentials returned an error for a credential-helper miss: %v", err)
+	}
+	if c.username != "" || c.password != "" {
+		t.Errorf("resolveCredentials set username=%q password=%q for a helper miss, want anonymous", c.username, c.password)
+	}
+}
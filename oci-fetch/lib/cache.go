@@ -0,0 +1,216 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SetCache tells Fetch to send "If-None-Match: etag" when requesting the
+// image's manifest by tag, so the registry can report that it's unchanged
+// (HTTP 304) instead of sending it again. etag is normally a manifest
+// digest previously returned by Cache.
+func (of *OCIFetcher) SetCache(etag string) {
+	of.cachedManifestDigest = etag
+}
+
+// Cache returns the digest of the manifest fetched by the most recent
+// call to Fetch, to be passed to SetCache on a future OCIFetcher so it can
+// detect whether the image has changed.
+func (of *OCIFetcher) Cache() string {
+	return of.manifestDigest
+}
+
+// SetCacheDir configures Fetch to use dir as a persistent blob cache:
+// blobs are stored there across invocations and reused instead of being
+// re-downloaded, and the last-seen manifest digest for each reference is
+// recorded so repeated fetches of an unchanged image skip the network
+// almost entirely.
+func (of *OCIFetcher) SetCacheDir(dir string) error {
+	if dir == "" {
+		of.cacheDir = ""
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		return fmt.Errorf("creating cache directory: %v", err)
+	}
+	of.cacheDir = dir
+	return nil
+}
+
+// cacheIndexEntry records, for a single "host/name:tag" reference, the
+// manifest digest last seen and when.
+type cacheIndexEntry struct {
+	ManifestDigest string `json:"manifestDigest"`
+	FetchedAt      string `json:"fetchedAt"`
+}
+
+// cacheIndex is the content of a cache directory's "index.json": a map
+// from "host/name:tag" to the last manifest digest fetched for it.
+type cacheIndex map[string]cacheIndexEntry
+
+func cacheIndexPath(cacheDir string) string {
+	return filepath.Join(cacheDir, "index.json")
+}
+
+func cacheKey(u *URL) string {
+	return fmt.Sprintf("%s/%s:%s", u.Host, u.Name, u.Tag)
+}
+
+// readCacheIndex looks up the cached manifest digest for u in cacheDir's
+// index, if any.
+func readCacheIndex(cacheDir string, u *URL) (digest string, ok bool, err error) {
+	b, err := ioutil.ReadFile(cacheIndexPath(cacheDir))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("reading cache index: %v", err)
+	}
+
+	var idx cacheIndex
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return "", false, fmt.Errorf("parsing cache index: %v", err)
+	}
+
+	entry, ok := idx[cacheKey(u)]
+	return entry.ManifestDigest, ok, nil
+}
+
+// writeCacheIndex records digest as the manifest digest fetched for u in
+// cacheDir's index.
+func writeCacheIndex(cacheDir string, u *URL, digest string) error {
+	path := cacheIndexPath(cacheDir)
+
+	idx := cacheIndex{}
+	if b, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(b, &idx)
+	}
+
+	idx[cacheKey(u)] = cacheIndexEntry{
+		ManifestDigest: digest,
+		FetchedAt:      time.Now().UTC().Format(time.RFC3339),
+	}
+
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+func blobCachePath(cacheDir, digest string) (string, error) {
+	name, err := digestHex(digest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "blobs", "sha256", name), nil
+}
+
+// readCachedBlob reads the blob identified by digest out of cacheDir.
+func readCachedBlob(cacheDir, digest string) ([]byte, error) {
+	path, err := blobCachePath(cacheDir, digest)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// linkCachedBlob hardlinks (falling back to a copy, e.g. across devices)
+// the cached blob identified by digest into destDir, if present in
+// cacheDir. It reports whether the blob was found.
+func linkCachedBlob(cacheDir, digest, destDir string) (bool, error) {
+	src, err := blobCachePath(cacheDir, digest)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	name, err := digestHex(digest)
+	if err != nil {
+		return false, err
+	}
+	dst := filepath.Join(destDir, name)
+
+	if err := linkOrCopy(src, dst); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// cacheBlob writes b into cacheDir under digest's name, if not already
+// present.
+func cacheBlob(cacheDir, digest string, b []byte) error {
+	path, err := blobCachePath(cacheDir, digest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// populateCacheBlob hardlinks (or copies) the already-downloaded blob
+// digest, found in srcDir, into cacheDir for reuse by future fetches.
+func populateCacheBlob(cacheDir, digest, srcDir string) error {
+	name, err := digestHex(digest)
+	if err != nil {
+		return err
+	}
+	src := filepath.Join(srcDir, name)
+
+	dst, err := blobCachePath(cacheDir, digest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	return linkOrCopy(src, dst)
+}
+
+// linkOrCopy hardlinks dst to src, falling back to a full copy if they're
+// on different filesystems.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
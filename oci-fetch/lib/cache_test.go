@@ -0,0 +1,56 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import "testing"
+
+func TestCacheKey(t *testing.T) {
+	u := &URL{Host: "registry.example.com", Name: "library/busybox", Tag: "1.36"}
+	want := "registry.example.com/library/busybox:1.36"
+	if got := cacheKey(u); got != want {
+		t.Errorf("cacheKey(%+v) = %q, want %q", u, got, want)
+	}
+}
+
+func TestWriteAndReadCacheIndex(t *testing.T) {
+	dir := t.TempDir()
+	u := &URL{Host: "registry.example.com", Name: "library/busybox", Tag: "1.36"}
+
+	if _, ok, err := readCacheIndex(dir, u); err != nil {
+		t.Fatalf("readCacheIndex on empty dir returned error: %v", err)
+	} else if ok {
+		t.Fatal("readCacheIndex on empty dir returned ok=true, want false")
+	}
+
+	const digest = "sha256:" + "ab"
+	if err := writeCacheIndex(dir, u, digest); err != nil {
+		t.Fatalf("writeCacheIndex: %v", err)
+	}
+
+	got, ok, err := readCacheIndex(dir, u)
+	if err != nil {
+		t.Fatalf("readCacheIndex: %v", err)
+	}
+	if !ok || got != digest {
+		t.Errorf("readCacheIndex = (%q, %v), want (%q, true)", got, ok, digest)
+	}
+
+	other := &URL{Host: "registry.example.com", Name: "library/busybox", Tag: "latest"}
+	if _, ok, err := readCacheIndex(dir, other); err != nil {
+		t.Fatalf("readCacheIndex for other tag: %v", err)
+	} else if ok {
+		t.Error("readCacheIndex for a different tag returned ok=true, want false")
+	}
+}
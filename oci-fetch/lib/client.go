@@ -0,0 +1,84 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lib implements the OCI/Docker registry v2 client shared by
+// oci-fetch's OCIFetcher and OCIPusher.
+package lib
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// registryClient holds everything needed to authenticate against a v2
+// registry: static credentials, an optional authfile, and the transport
+// settings used to reach it. OCIFetcher and OCIPusher both embed one so
+// they share a single auth/token implementation.
+type registryClient struct {
+	username      string
+	password      string
+	identityToken string
+
+	insecureAllowHTTP           bool
+	insecureSkipTLSVerification bool
+	debug                       bool
+
+	authConfig *dockerConfig
+
+	client *http.Client
+}
+
+func newRegistryClient(username, password string, insecureAllowHTTP, insecureSkipTLSVerification, debug bool) registryClient {
+	return registryClient{
+		username:                    username,
+		password:                    password,
+		insecureAllowHTTP:           insecureAllowHTTP,
+		insecureSkipTLSVerification: insecureSkipTLSVerification,
+		debug:                       debug,
+	}
+}
+
+func (c *registryClient) httpClient() *http.Client {
+	if c.client == nil {
+		c.client = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: c.insecureSkipTLSVerification},
+			},
+		}
+	}
+	return c.client
+}
+
+func (c *registryClient) debugf(format string, args ...interface{}) {
+	if c.debug {
+		fmt.Fprintf(os.Stderr, "debug: "+format+"\n", args...)
+	}
+}
+
+func (c *registryClient) registryScheme() string {
+	if c.insecureAllowHTTP {
+		return "http"
+	}
+	return "https"
+}
+
+func (c *registryClient) blobURL(u *URL, digest string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/blobs/%s", c.registryScheme(), u.Host, u.Name, digest)
+}
+
+func (c *registryClient) manifestURL(u *URL, ref string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/manifests/%s", c.registryScheme(), u.Host, u.Name, ref)
+}
@@ -0,0 +1,548 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lib implements the OCI/Docker registry v2 client used by
+// oci-fetch to pull an image into a local OCI image layout.
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// OCIFetcher fetches an OCI image from a v2 registry and lays it out on
+// disk as an OCI image layout (see
+// https://github.com/opencontainers/image-spec/blob/main/image-layout.md).
+type OCIFetcher struct {
+	registryClient
+
+	cacheDir             string
+	cachedManifestDigest string
+	manifestDigest       string
+
+	withReferrers bool
+
+	platform     *Platform
+	allPlatforms bool
+
+	maxParallelDownloads int
+	progressMode         ProgressMode
+	progress             *progress
+}
+
+// defaultMaxParallelDownloads is how many blobs Fetch downloads at once
+// when SetMaxParallelDownloads hasn't been called.
+const defaultMaxParallelDownloads = 4
+
+// NewOCIFetcher returns an OCIFetcher that authenticates with the given
+// username and password, if any.
+func NewOCIFetcher(username, password string, insecureAllowHTTP, insecureSkipTLSVerification, debug bool) *OCIFetcher {
+	return &OCIFetcher{
+		registryClient: newRegistryClient(username, password, insecureAllowHTTP, insecureSkipTLSVerification, debug),
+	}
+}
+
+// Fetch pulls the image referenced by u and lays it out inside dir as an
+// OCI image layout. If a cache directory was configured via SetCacheDir
+// and the registry reports the manifest unchanged (via If-None-Match), the
+// cached manifest and blobs are reused and nothing is re-downloaded.
+func (of *OCIFetcher) Fetch(u *URL, dir string) error {
+	if err := of.resolveCredentials(u.Host); err != nil {
+		return err
+	}
+
+	if of.cacheDir != "" && of.cachedManifestDigest == "" {
+		if digest, ok, err := readCacheIndex(of.cacheDir, u); err != nil {
+			return err
+		} else if ok {
+			of.cachedManifestDigest = digest
+		}
+	}
+
+	manifestDigest, manifestBytes, err := of.fetchManifestBlob(u, u.Tag, of.cachedManifestDigest)
+	if err != nil {
+		return err
+	}
+	of.manifestDigest = manifestDigest
+
+	if manifestBytes == nil {
+		// Not modified: the registry confirmed manifestDigest is still
+		// current, so pull its (and its blobs') bytes from the cache
+		// instead of the network.
+		of.debugf("manifest %s unchanged, reusing cache", manifestDigest)
+		manifestBytes, err = readCachedBlob(of.cacheDir, manifestDigest)
+		if err != nil {
+			return fmt.Errorf("reading cached manifest: %v", err)
+		}
+	}
+
+	topDigest, topBytes := manifestDigest, manifestBytes
+
+	mediaType, isIndex, err := manifestKind(manifestBytes)
+	if err != nil {
+		return fmt.Errorf("decoding manifest: %v", err)
+	}
+	if isIndex {
+		if of.allPlatforms {
+			return of.fetchAllPlatforms(u, dir, manifestDigest, manifestBytes, mediaType)
+		}
+
+		childDigest, childBytes, err := of.selectFromIndex(u, manifestBytes)
+		if err != nil {
+			return err
+		}
+		manifestDigest, manifestBytes = childDigest, childBytes
+		of.manifestDigest = manifestDigest
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return fmt.Errorf("decoding manifest: %v", err)
+	}
+
+	blobDir := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return fmt.Errorf("creating blob directory: %v", err)
+	}
+
+	of.progress = newProgress(of.progressMode)
+
+	blobs := append([]Descriptor{manifest.Config}, manifest.Layers...)
+	if err := of.fetchBlobsParallel(u, blobs, blobDir); err != nil {
+		return err
+	}
+
+	if err := writeBlob(blobDir, manifestDigest, manifestBytes); err != nil {
+		return err
+	}
+	if of.cacheDir != "" {
+		if err := cacheBlob(of.cacheDir, manifestDigest, manifestBytes); err != nil {
+			return err
+		}
+		if isIndex {
+			// The tag resolves to the index, not the platform we selected
+			// out of it, so the cache entry for the tag (and the
+			// If-None-Match sent on the next fetch) must key off the
+			// index's own digest, not the child's.
+			if err := cacheBlob(of.cacheDir, topDigest, topBytes); err != nil {
+				return err
+			}
+		}
+		if err := writeCacheIndex(of.cacheDir, u, topDigest); err != nil {
+			return err
+		}
+	}
+
+	if of.withReferrers {
+		if err := of.fetchAndWriteReferrers(u, dir, blobDir); err != nil {
+			return fmt.Errorf("fetching referrers: %v", err)
+		}
+	}
+
+	return writeImageLayout(dir, manifestDigest, int64(len(manifestBytes)), manifest.MediaType, u)
+}
+
+// selectFromIndex decodes indexBytes as an image index/manifest list and
+// fetches the single child manifest matching of.platform (or the host's
+// own platform if none was set), returning its digest and body.
+func (of *OCIFetcher) selectFromIndex(u *URL, indexBytes []byte) (string, []byte, error) {
+	var index ociIndex
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return "", nil, fmt.Errorf("decoding image index: %v", err)
+	}
+
+	want := of.platform
+	if want == nil {
+		want = defaultPlatform()
+	}
+
+	var available []string
+	for _, m := range index.Manifests {
+		if m.Platform != nil {
+			available = append(available, m.Platform.String())
+		}
+		if m.Platform.matches(want) {
+			of.debugf("selected manifest %s for platform %s", m.Digest, want)
+			if of.cacheDir != "" {
+				if body, err := readCachedBlob(of.cacheDir, m.Digest); err == nil && digestOf(body) == m.Digest {
+					return m.Digest, body, nil
+				}
+			}
+			return of.fetchManifestBlob(u, m.Digest, "")
+		}
+	}
+
+	return "", nil, fmt.Errorf("no manifest for platform %s in %s; available: %s", want, u, strings.Join(available, ", "))
+}
+
+// fetchAllPlatforms fetches every entry of an image index/manifest list,
+// plus its own blobs, into dir, rather than selecting a single platform.
+// The index itself becomes dir's top-level manifest. Blobs go through the
+// cache directory as usual, but the index's digest is not recorded in the
+// cache index and every child manifest is requested from the registry.
+func (of *OCIFetcher) fetchAllPlatforms(u *URL, dir, indexDigest string, indexBytes []byte, indexMediaType string) error {
+	var index ociIndex
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return fmt.Errorf("decoding image index: %v", err)
+	}
+
+	blobDir := filepath.Join(dir, "blobs", "sha256")
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return fmt.Errorf("creating blob directory: %v", err)
+	}
+
+	of.progress = newProgress(of.progressMode)
+
+	for _, m := range index.Manifests {
+		digest, body, err := of.fetchManifestBlob(u, m.Digest, "")
+		if err != nil {
+			return fmt.Errorf("fetching manifest for %s: %v", platformLabel(m.Platform), err)
+		}
+		if err := writeBlob(blobDir, digest, body); err != nil {
+			return err
+		}
+
+		var child Manifest
+		if err := json.Unmarshal(body, &child); err != nil {
+			return fmt.Errorf("decoding manifest for %s: %v", platformLabel(m.Platform), err)
+		}
+
+		blobs := append([]Descriptor{child.Config}, child.Layers...)
+		if err := of.fetchBlobsParallel(u, blobs, blobDir); err != nil {
+			return fmt.Errorf("fetching blobs for %s: %v", platformLabel(m.Platform), err)
+		}
+	}
+
+	if err := writeBlob(blobDir, indexDigest, indexBytes); err != nil {
+		return err
+	}
+
+	if of.withReferrers {
+		if err := of.fetchAndWriteReferrers(u, dir, blobDir); err != nil {
+			return fmt.Errorf("fetching referrers: %v", err)
+		}
+	}
+
+	return writeImageLayout(dir, indexDigest, int64(len(indexBytes)), indexMediaType, u)
+}
+
+// fetchManifestBlob requests the raw manifest for ref, returning its
+// digest and body. If ifNoneMatch is non-empty and the registry confirms
+// the manifest still matches it (HTTP 304), body is nil.
+func (of *OCIFetcher) fetchManifestBlob(u *URL, ref, ifNoneMatch string) (string, []byte, error) {
+	req, err := http.NewRequest("GET", of.manifestURL(u, ref), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestAccept, ", "))
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+
+	of.debugf("GET %s", req.URL)
+
+	resp, err := of.authenticate(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("fetching manifest %s: %v", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return ifNoneMatch, nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("fetching manifest %s: %s", u, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading manifest %s: %v", u, err)
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = digestOf(body)
+	}
+
+	return digest, body, nil
+}
+
+// fetchBlobsParallel fetches descriptors into dir using up to
+// SetMaxParallelDownloads workers at once. Descriptors sharing a digest
+// (e.g. a manifest listing the same empty layer twice) are fetched once.
+func (of *OCIFetcher) fetchBlobsParallel(u *URL, descriptors []Descriptor, dir string) error {
+	descriptors = dedupeDescriptors(descriptors)
+
+	workers := of.maxParallelDownloads
+	if workers <= 0 {
+		workers = defaultMaxParallelDownloads
+	}
+	if workers > len(descriptors) {
+		workers = len(descriptors)
+	}
+
+	jobs := make(chan Descriptor)
+	errs := make(chan error, len(descriptors))
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for desc := range jobs {
+				if err := of.fetchBlobCached(u, desc.Digest, desc.Size, dir); err != nil {
+					errs <- fmt.Errorf("fetching %s: %v", desc.Digest, err)
+				}
+			}
+		}()
+	}
+
+	for _, desc := range descriptors {
+		jobs <- desc
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		return err
+	}
+	return nil
+}
+
+// dedupeDescriptors returns descriptors with later entries sharing a
+// digest with an earlier one removed, preserving order.
+func dedupeDescriptors(descriptors []Descriptor) []Descriptor {
+	seen := make(map[string]bool, len(descriptors))
+	deduped := make([]Descriptor, 0, len(descriptors))
+	for _, desc := range descriptors {
+		if seen[desc.Digest] {
+			continue
+		}
+		seen[desc.Digest] = true
+		deduped = append(deduped, desc)
+	}
+	return deduped
+}
+
+// fetchBlobCached is fetchBlob, but first checks the configured cache
+// directory for an existing copy of digest to link or copy into dir
+// instead of downloading it again.
+func (of *OCIFetcher) fetchBlobCached(u *URL, digest string, size int64, dir string) error {
+	if of.cacheDir != "" {
+		if linked, err := linkCachedBlob(of.cacheDir, digest, dir); err != nil {
+			return err
+		} else if linked {
+			of.debugf("blob %s found in cache", digest)
+			of.progress.cached(digest)
+			return nil
+		}
+	}
+
+	if err := of.fetchBlob(u, digest, size, dir); err != nil {
+		return err
+	}
+
+	if of.cacheDir != "" {
+		if err := populateCacheBlob(of.cacheDir, digest, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fetchBlob downloads the blob identified by digest into dir, named after
+// its digest, verifying the content against it as it streams. If a
+// partial download from a previous attempt is found in dir, it's resumed
+// with a Range request instead of starting over.
+func (of *OCIFetcher) fetchBlob(u *URL, digest string, size int64, dir string) error {
+	name, err := digestHex(digest)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+
+	h := sha256.New()
+	var startOffset int64
+
+	if fi, err := os.Stat(path); err == nil {
+		switch {
+		case fi.Size() == size && verifyDigest(path, digest) == nil:
+			return nil
+		case fi.Size() < size:
+			if existing, err := os.Open(path); err == nil {
+				io.Copy(h, existing)
+				existing.Close()
+				startOffset = fi.Size()
+			}
+		default:
+			os.Remove(path)
+		}
+	}
+
+	req, err := http.NewRequest("GET", of.blobURL(u, digest), nil)
+	if err != nil {
+		return err
+	}
+	if startOffset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", startOffset))
+	}
+
+	of.debugf("GET %s", req.URL)
+
+	resp, err := of.authenticate(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var f *os.File
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	case http.StatusOK:
+		// The server ignored our Range request and is sending the whole
+		// blob again; start the file and its digest over.
+		startOffset = 0
+		h = sha256.New()
+		f, err = os.Create(path)
+	default:
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	of.progress.start(digest, size)
+	if _, err := io.Copy(io.MultiWriter(f, h, progressWriter{of.progress, digest}), resp.Body); err != nil {
+		return err
+	}
+	of.progress.done(digest)
+
+	if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); got != digest {
+		os.Remove(path)
+		return fmt.Errorf("digest mismatch for %s: got %s", digest, got)
+	}
+
+	return nil
+}
+
+// verifyDigest reports whether the content at path matches digest.
+func verifyDigest(path, digest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); got != digest {
+		return fmt.Errorf("digest mismatch for %s: got %s", digest, got)
+	}
+	return nil
+}
+
+// digestOf returns the sha256 digest of b in "sha256:<hex>" form.
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("sha256:%x", sum[:])
+}
+
+// digestHexPattern matches the 64 lowercase hex characters of a sha256
+// digest's hex half, rejecting anything (including path separators or
+// "..") that could escape the directory it's joined into.
+var digestHexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// digestHex returns the hex-encoded half of a "sha256:<hex>" digest. It's
+// used to build filesystem paths, so it validates that the result is
+// exactly 64 lowercase hex characters, since digests come straight off a
+// registry's manifest/index JSON and can't otherwise be trusted.
+func digestHex(digest string) (string, error) {
+	const prefix = "sha256:"
+	if !strings.HasPrefix(digest, prefix) {
+		return "", fmt.Errorf("unsupported digest algorithm: %s", digest)
+	}
+	hex := strings.TrimPrefix(digest, prefix)
+	if !digestHexPattern.MatchString(hex) {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return hex, nil
+}
+
+// writeBlob writes b to dir under its digest's hex name, replacing any
+// file already there.
+func writeBlob(dir, digest string, b []byte) error {
+	name, err := digestHex(digest)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, name), b, 0644)
+}
+
+// ociLayout is the content of the image layout's "oci-layout" file.
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// ociIndex is the content of the image layout's "index.json" file.
+type ociIndex struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// writeImageLayout writes the "oci-layout" and "index.json" files that
+// make dir a valid OCI image layout pointing at the given manifest.
+func writeImageLayout(dir, manifestDigest string, manifestSize int64, manifestMediaType string, u *URL) error {
+	layout, err := json.Marshal(ociLayout{ImageLayoutVersion: "1.0.0"})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "oci-layout"), layout, 0644); err != nil {
+		return err
+	}
+
+	index, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		Manifests: []Descriptor{
+			{
+				MediaType: manifestMediaType,
+				Digest:    manifestDigest,
+				Size:      manifestSize,
+				Annotations: map[string]string{
+					annotationSourceRepo: u.Host + "/" + u.Name,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "index.json"), index, 0644)
+}
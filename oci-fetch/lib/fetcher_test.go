@@ -0,0 +1,195 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testURL returns a URL pointing at an httptest server's host.
+func testURL(server *httptest.Server) *URL {
+	return &URL{Host: strings.TrimPrefix(server.URL, "http://"), Name: "library/x", Tag: "latest"}
+}
+
+func newInsecureFetcher() *OCIFetcher {
+	of := NewOCIFetcher("", "", true, false, false)
+	of.progress = newProgress(ProgressNone)
+	return of
+}
+
+func TestFetchBlobRejectsPathTraversalDigest(t *testing.T) {
+	of := newInsecureFetcher()
+	dir := t.TempDir()
+	u := &URL{Host: "registry.example.com", Name: "library/x", Tag: "latest"}
+
+	evil := "sha256:../../../../tmp/oci-fetch-test-escape"
+	if err := of.fetchBlob(u, evil, 4, dir); err == nil {
+		t.Fatal("fetchBlob accepted a path-traversal digest, want an error")
+	}
+	if _, err := os.Stat("/tmp/oci-fetch-test-escape"); !os.IsNotExist(err) {
+		os.Remove("/tmp/oci-fetch-test-escape")
+		t.Fatal("fetchBlob wrote a file outside the destination directory")
+	}
+}
+
+func TestFetchBlobResumesPartialDownload(t *testing.T) {
+	content := []byte("the quick brown fox jumps over the lazy dog")
+	digest := digestOf(content)
+	hex, err := digestHex(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/library/x/blobs/"+digest, func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		if rng == "" {
+			w.WriteHeader(http.StatusOK)
+			w.Write(content)
+			return
+		}
+		var start int
+		if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err != nil {
+			t.Errorf("unparseable Range header %q", rng)
+		}
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start:])
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, hex), content[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	of := newInsecureFetcher()
+	u := testURL(server)
+	if err := of.fetchBlob(u, digest, int64(len(content)), dir); err != nil {
+		t.Fatalf("fetchBlob: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, hex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("resumed download = %q, want %q", got, content)
+	}
+}
+
+func TestFetchBlobDigestMismatchRemovesFile(t *testing.T) {
+	content := []byte("real content")
+	wrongDigest := digestOf([]byte("different content"))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/library/x/blobs/"+wrongDigest, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+	of := newInsecureFetcher()
+	u := testURL(server)
+
+	if err := of.fetchBlob(u, wrongDigest, int64(len(content)), dir); err == nil {
+		t.Fatal("fetchBlob accepted content that doesn't match its digest")
+	}
+
+	hex, _ := digestHex(wrongDigest)
+	if _, err := os.Stat(filepath.Join(dir, hex)); !os.IsNotExist(err) {
+		t.Error("fetchBlob left a file behind after a digest mismatch")
+	}
+}
+
+func TestFetchBlobsParallelDedupesDigests(t *testing.T) {
+	content := []byte("layer bytes")
+	digest := digestOf(content)
+
+	var requests int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/library/x/blobs/"+digest, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+	of := newInsecureFetcher()
+	u := testURL(server)
+
+	descriptors := []Descriptor{
+		{Digest: digest, Size: int64(len(content))},
+		{Digest: digest, Size: int64(len(content))},
+	}
+	if err := of.fetchBlobsParallel(u, descriptors, dir); err != nil {
+		t.Fatalf("fetchBlobsParallel: %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("got %d requests for a digest listed twice, want 1", got)
+	}
+}
+
+func TestFetchReferrersFallsBackToTagSchema(t *testing.T) {
+	subjectDigest := digestOf([]byte("subject manifest"))
+	hex, err := digestHex(subjectDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	referrerManifest := []byte(fmt.Sprintf(
+		`{"schemaVersion":2,"mediaType":%q,"config":{"digest":"sha256:%s","size":2}}`,
+		mediaTypeOCIManifest, strings.Repeat("a", 64)))
+	referrerDigest := digestOf(referrerManifest)
+	referrerTag := "sha256-" + hex + ".sig"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/library/x/referrers/"+subjectDigest, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/v2/library/x/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]string{"tags": {referrerTag, "latest"}})
+	})
+	mux.HandleFunc("/v2/library/x/manifests/"+referrerTag, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", referrerDigest)
+		w.Write(referrerManifest)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	of := newInsecureFetcher()
+	of.manifestDigest = subjectDigest
+	u := testURL(server)
+
+	descriptors, err := of.FetchReferrers(u, "")
+	if err != nil {
+		t.Fatalf("FetchReferrers: %v", err)
+	}
+	if len(descriptors) != 1 || descriptors[0].Digest != referrerDigest {
+		t.Errorf("FetchReferrers = %+v, want one descriptor with digest %s", descriptors, referrerDigest)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import "encoding/json"
+
+const (
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+)
+
+// manifestAccept is the set of manifest media types this fetcher knows how
+// to handle, sent as the Accept header when requesting a manifest.
+var manifestAccept = []string{
+	mediaTypeOCIManifest,
+	mediaTypeDockerManifest,
+	mediaTypeOCIIndex,
+	mediaTypeDockerManifestList,
+}
+
+// Descriptor is an OCI content descriptor: a reference to a blob by digest,
+// along with its media type and size.
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Platform     *Platform         `json:"platform,omitempty"`
+}
+
+// isIndexMediaType reports whether mediaType identifies an image
+// index/manifest list rather than a single image manifest.
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == mediaTypeOCIIndex || mediaType == mediaTypeDockerManifestList
+}
+
+// manifestKind sniffs a manifest GET response body to tell whether it's a
+// multi-platform image index/manifest list or a single image manifest,
+// without assuming either shape up front. Some registries omit mediaType
+// on manifest lists, so a non-empty manifests array is also taken as a
+// sign of an index.
+func manifestKind(body []byte) (mediaType string, isIndex bool, err error) {
+	var env struct {
+		MediaType string       `json:"mediaType"`
+		Manifests []Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &env); err != nil {
+		return "", false, err
+	}
+	if env.MediaType != "" {
+		return env.MediaType, isIndexMediaType(env.MediaType), nil
+	}
+	if len(env.Manifests) > 0 {
+		return mediaTypeOCIIndex, true, nil
+	}
+	return "", false, nil
+}
+
+// annotationSourceRepo records, on the manifest descriptor in an image
+// layout's index.json, the "host/name" repository the image was fetched
+// from. OCIPusher uses it to attempt a cross-repo blob mount when pushing
+// back to the same registry.
+const annotationSourceRepo = "io.github.containers.oci-fetch.source-repo"
+
+// Manifest is an OCI/Docker v2 image manifest: a config blob plus an
+// ordered list of filesystem layer blobs.
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
@@ -0,0 +1,69 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import "testing"
+
+func TestManifestKind(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantMediaType string
+		wantIsIndex   bool
+	}{
+		{
+			name:          "image manifest",
+			body:          `{"mediaType":"` + mediaTypeOCIManifest + `"}`,
+			wantMediaType: mediaTypeOCIManifest,
+			wantIsIndex:   false,
+		},
+		{
+			name:          "image index",
+			body:          `{"mediaType":"` + mediaTypeOCIIndex + `"}`,
+			wantMediaType: mediaTypeOCIIndex,
+			wantIsIndex:   true,
+		},
+		{
+			name:          "manifest list without mediaType",
+			body:          `{"schemaVersion":2,"manifests":[{"digest":"sha256:abc"}]}`,
+			wantMediaType: mediaTypeOCIIndex,
+			wantIsIndex:   true,
+		},
+		{
+			name:          "manifest without mediaType",
+			body:          `{"schemaVersion":2,"config":{"digest":"sha256:abc"}}`,
+			wantMediaType: "",
+			wantIsIndex:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mediaType, isIndex, err := manifestKind([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("manifestKind returned error: %v", err)
+			}
+			if mediaType != tt.wantMediaType || isIndex != tt.wantIsIndex {
+				t.Errorf("manifestKind(%s) = (%q, %v), want (%q, %v)", tt.body, mediaType, isIndex, tt.wantMediaType, tt.wantIsIndex)
+			}
+		})
+	}
+}
+
+func TestManifestKindInvalidJSON(t *testing.T) {
+	if _, _, err := manifestKind([]byte("not json")); err == nil {
+		t.Error("manifestKind returned nil error for invalid JSON, want one")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Platform identifies one entry of a multi-arch image index/manifest
+// list, e.g. "linux/amd64" or "linux/arm64/v8".
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform parses an "os/arch[/variant]" string as passed to
+// --platform.
+func ParsePlatform(s string) (*Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid platform %q: expected os/arch[/variant]", s)
+	}
+
+	p := &Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// defaultPlatform returns the platform of the host this binary is running
+// on, used when --platform isn't given.
+func defaultPlatform() *Platform {
+	return &Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+}
+
+// String returns the "os/arch[/variant]" form of p.
+func (p *Platform) String() string {
+	if p.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", p.OS, p.Architecture, p.Variant)
+	}
+	return fmt.Sprintf("%s/%s", p.OS, p.Architecture)
+}
+
+// matches reports whether p satisfies the requested platform want. An
+// empty Variant on want matches any variant.
+func (p *Platform) matches(want *Platform) bool {
+	if p == nil {
+		return false
+	}
+	if p.OS != want.OS || p.Architecture != want.Architecture {
+		return false
+	}
+	return want.Variant == "" || p.Variant == want.Variant
+}
+
+// platformLabel formats p for an error or log message, tolerating a nil
+// Platform on a manifest list entry that omitted one.
+func platformLabel(p *Platform) string {
+	if p == nil {
+		return "unknown platform"
+	}
+	return p.String()
+}
+
+// SetPlatform restricts Fetch to the image index entry matching p. If p is
+// nil, the host's own OS/architecture is used. Has no effect unless the
+// registry returns an image index/manifest list.
+func (of *OCIFetcher) SetPlatform(p *Platform) {
+	of.platform = p
+}
+
+// SetAllPlatforms makes Fetch pull every entry of an image index/manifest
+// list, instead of selecting one by platform.
+func (of *OCIFetcher) SetAllPlatforms(all bool) {
+	of.allPlatforms = all
+}
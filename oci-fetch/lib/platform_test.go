@@ -0,0 +1,76 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import "testing"
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Platform
+	}{
+		{"linux/amd64", Platform{OS: "linux", Architecture: "amd64"}},
+		{"linux/arm64/v8", Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePlatform(tt.s)
+		if err != nil {
+			t.Fatalf("ParsePlatform(%q) returned error: %v", tt.s, err)
+		}
+		if *got != tt.want {
+			t.Errorf("ParsePlatform(%q) = %+v, want %+v", tt.s, *got, tt.want)
+		}
+	}
+}
+
+func TestParsePlatformInvalid(t *testing.T) {
+	tests := []string{"linux", "linux/arm64/v8/extra", ""}
+
+	for _, s := range tests {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("ParsePlatform(%q) returned nil error, want one", s)
+		}
+	}
+}
+
+func TestPlatformMatches(t *testing.T) {
+	amd64 := &Platform{OS: "linux", Architecture: "amd64"}
+	armV7 := &Platform{OS: "linux", Architecture: "arm", Variant: "v7"}
+	armV8 := &Platform{OS: "linux", Architecture: "arm", Variant: "v8"}
+
+	tests := []struct {
+		name string
+		p    *Platform
+		want *Platform
+		ok   bool
+	}{
+		{"exact match", amd64, amd64, true},
+		{"os mismatch", &Platform{OS: "windows", Architecture: "amd64"}, amd64, false},
+		{"arch mismatch", &Platform{OS: "linux", Architecture: "arm"}, amd64, false},
+		{"variant required and matching", armV7, armV7, true},
+		{"variant required but different", armV8, armV7, false},
+		{"variant unspecified matches any", armV7, &Platform{OS: "linux", Architecture: "arm"}, true},
+		{"nil entry never matches", nil, amd64, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.matches(tt.want); got != tt.ok {
+				t.Errorf("(%v).matches(%v) = %v, want %v", tt.p, tt.want, got, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// ProgressMode selects how Fetch reports download progress to stderr.
+type ProgressMode string
+
+const (
+	// ProgressAuto uses a live-updating status line if stderr is a
+	// terminal, and falls back to ProgressPlain otherwise.
+	ProgressAuto ProgressMode = "auto"
+	// ProgressPlain logs one line per blob start/finish, safe for
+	// non-interactive CI logs.
+	ProgressPlain ProgressMode = "plain"
+	// ProgressNone disables progress reporting entirely.
+	ProgressNone ProgressMode = "none"
+)
+
+// SetMaxParallelDownloads configures how many blobs Fetch downloads
+// concurrently. n <= 0 resets it to the default.
+func (of *OCIFetcher) SetMaxParallelDownloads(n int) {
+	of.maxParallelDownloads = n
+}
+
+// SetProgress configures how Fetch reports download progress. An empty
+// mode is equivalent to ProgressAuto.
+func (of *OCIFetcher) SetProgress(mode ProgressMode) error {
+	switch mode {
+	case ProgressAuto, ProgressPlain, ProgressNone, "":
+		of.progressMode = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown progress mode %q: expected auto, plain, or none", mode)
+	}
+}
+
+// progress reports per-blob byte counts to stderr as Fetch's workers pull
+// blobs down, similar to "docker pull".
+type progress struct {
+	mode string // "tty", "plain", or "none"
+
+	mu      sync.Mutex
+	order   []string
+	total   map[string]int64
+	written map[string]int64
+}
+
+func newProgress(mode ProgressMode) *progress {
+	resolved := string(mode)
+	if mode == ProgressAuto || mode == "" {
+		if term.IsTerminal(int(os.Stderr.Fd())) {
+			resolved = "tty"
+		} else {
+			resolved = "plain"
+		}
+	}
+
+	return &progress{
+		mode:    resolved,
+		total:   map[string]int64{},
+		written: map[string]int64{},
+	}
+}
+
+func (p *progress) start(digest string, total int64) {
+	if p.mode == "none" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total[digest] = total
+	p.written[digest] = 0
+	p.order = append(p.order, digest)
+
+	if p.mode == "plain" {
+		fmt.Fprintf(os.Stderr, "Pulling %s (%d bytes)\n", shortDigest(digest), total)
+		return
+	}
+	p.render()
+}
+
+func (p *progress) add(digest string, n int64) {
+	if p.mode != "tty" {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written[digest] += n
+	p.render()
+}
+
+func (p *progress) cached(digest string) {
+	if p.mode == "none" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: already in cache\n", shortDigest(digest))
+}
+
+func (p *progress) done(digest string) {
+	switch p.mode {
+	case "none":
+		return
+	case "plain":
+		fmt.Fprintf(os.Stderr, "%s done\n", shortDigest(digest))
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written[digest] = p.total[digest]
+	p.render()
+}
+
+// render rewrites a single status line summarizing every blob in flight.
+// Callers must hold p.mu.
+func (p *progress) render() {
+	fmt.Fprint(os.Stderr, "\r")
+	for _, digest := range p.order {
+		fmt.Fprintf(os.Stderr, "%s: %d/%d  ", shortDigest(digest), p.written[digest], p.total[digest])
+	}
+}
+
+// shortDigest abbreviates a "sha256:<hex>" digest for status output.
+func shortDigest(digest string) string {
+	hex, err := digestHex(digest)
+	if err != nil || len(hex) < 12 {
+		return digest
+	}
+	return hex[:12]
+}
+
+// progressWriter is an io.Writer adapter that reports bytes written to a
+// progress tracker, for use alongside a hashing/file io.MultiWriter.
+type progressWriter struct {
+	p      *progress
+	digest string
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	w.p.add(w.digest, int64(len(b)))
+	return len(b), nil
+}
@@ -0,0 +1,391 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OCIPusher is the inverse of OCIFetcher: it reads an OCI image layout
+// produced by Fetch (a .tar.gz of an "oci-layout" directory) and uploads
+// its blobs, manifest, and tag to a v2 registry.
+type OCIPusher struct {
+	registryClient
+}
+
+// NewOCIPusher returns an OCIPusher that authenticates with the given
+// username and password, if any.
+func NewOCIPusher(username, password string, insecureAllowHTTP, insecureSkipTLSVerification, debug bool) *OCIPusher {
+	return &OCIPusher{
+		registryClient: newRegistryClient(username, password, insecureAllowHTTP, insecureSkipTLSVerification, debug),
+	}
+}
+
+// Push extracts the image layout tarball at tarPath and uploads it to the
+// repository and tag referenced by u.
+func (op *OCIPusher) Push(tarPath string, u *URL) error {
+	if err := op.resolveCredentials(u.Host); err != nil {
+		return err
+	}
+
+	layoutDir, err := ioutil.TempDir("", "oci-fetch-push")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(layoutDir)
+
+	if err := extractLayout(tarPath, layoutDir); err != nil {
+		return fmt.Errorf("extracting %s: %v", tarPath, err)
+	}
+
+	index, err := readIndex(layoutDir)
+	if err != nil {
+		return err
+	}
+	if len(index.Manifests) == 0 {
+		return fmt.Errorf("%s: no manifest in index.json", tarPath)
+	}
+	manifestDesc := index.Manifests[0]
+	fromRepo := manifestDesc.Annotations[annotationSourceRepo]
+
+	blobDir := filepath.Join(layoutDir, "blobs", "sha256")
+	manifestBytes, err := readBlob(blobDir, manifestDesc.Digest)
+	if err != nil {
+		return err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return fmt.Errorf("decoding manifest: %v", err)
+	}
+
+	if err := op.pushBlob(u, blobDir, manifest.Config, fromRepo); err != nil {
+		return fmt.Errorf("pushing config: %v", err)
+	}
+	for _, layer := range manifest.Layers {
+		if err := op.pushBlob(u, blobDir, layer, fromRepo); err != nil {
+			return fmt.Errorf("pushing layer %s: %v", layer.Digest, err)
+		}
+	}
+
+	return op.pushManifest(u, manifestDesc.MediaType, manifestBytes)
+}
+
+// blobExists checks whether digest is already present in the repository,
+// via a blob HEAD request.
+func (op *OCIPusher) blobExists(u *URL, digest string) (bool, error) {
+	req, err := http.NewRequest("HEAD", op.blobURL(u, digest), nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := op.authenticate(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// pushBlob uploads the blob described by desc, skipping the upload
+// entirely if it's already present in the destination repository, and
+// otherwise trying a cross-repo mount from fromRepo (the repository the
+// blob was originally fetched from, if known and on the same registry)
+// before falling back to a full upload.
+func (op *OCIPusher) pushBlob(u *URL, blobDir string, desc Descriptor, fromRepo string) error {
+	exists, err := op.blobExists(u, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		op.debugf("blob %s already present, skipping", desc.Digest)
+		return nil
+	}
+
+	if fromName, ok := sameRegistryRepo(fromRepo, u.Host); ok && fromName != u.Name {
+		mounted, err := op.mountBlob(u, desc.Digest, fromName)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			op.debugf("blob %s mounted from %s", desc.Digest, fromName)
+			return nil
+		}
+	}
+
+	name, err := digestHex(desc.Digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filepath.Join(blobDir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sessionURL, err := op.startUpload(u)
+	if err != nil {
+		return fmt.Errorf("starting upload: %v", err)
+	}
+
+	sessionURL, err = op.patchBlob(sessionURL, f, desc.Size)
+	if err != nil {
+		return fmt.Errorf("uploading blob: %v", err)
+	}
+
+	return op.finishUpload(sessionURL, desc.Digest)
+}
+
+// sameRegistryRepo reports whether fromRepo (a "host/name" string recorded
+// at fetch time) names a repository on host, returning its bare name if so.
+func sameRegistryRepo(fromRepo, host string) (name string, ok bool) {
+	prefix := host + "/"
+	if !strings.HasPrefix(fromRepo, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(fromRepo, prefix), true
+}
+
+// mountBlob attempts a cross-repo blob mount, avoiding a full re-upload of
+// a blob that already exists in fromRepo on the same registry. It returns
+// false if the registry didn't accept the mount and a normal upload is
+// still required.
+func (op *OCIPusher) mountBlob(u *URL, digest, fromRepo string) (bool, error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/blobs/uploads/?mount=%s&from=%s", op.registryScheme(), u.Host, u.Name, digest, fromRepo)
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := op.authenticate(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusCreated, nil
+}
+
+// startUpload begins a blob upload session, returning the session URL to
+// PATCH/PUT against.
+func (op *OCIPusher) startUpload(u *URL) (string, error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/blobs/uploads/", op.registryScheme(), u.Host, u.Name)
+
+	req, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := op.authenticate(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+
+	return resolveLocation(req, resp)
+}
+
+// patchBlob streams r to the upload session at sessionURL and returns the
+// (possibly updated) session URL for the next request.
+func (op *OCIPusher) patchBlob(sessionURL string, r io.ReadSeeker, size int64) (string, error) {
+	req, err := http.NewRequest("PATCH", sessionURL, r)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Range", fmt.Sprintf("0-%d", size-1))
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(r), nil
+	}
+
+	resp, err := op.authenticate(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+
+	return resolveLocation(req, resp)
+}
+
+// resolveLocation returns resp's Location header resolved against the
+// request that produced it, since the distribution spec permits
+// registries to return a relative Location for an upload session.
+func resolveLocation(req *http.Request, resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	resolved, err := req.URL.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("parsing Location %q: %v", location, err)
+	}
+	return resolved.String(), nil
+}
+
+// finishUpload completes an upload session by PUTting its final digest.
+func (op *OCIPusher) finishUpload(sessionURL, digest string) error {
+	url := sessionURL
+	if strings.Contains(url, "?") {
+		url += "&digest=" + digest
+	} else {
+		url += "?digest=" + digest
+	}
+
+	req, err := http.NewRequest("PUT", url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := op.authenticate(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	return nil
+}
+
+// pushManifest uploads the manifest to the tag referenced by u.
+func (op *OCIPusher) pushManifest(u *URL, mediaType string, body []byte) error {
+	req, err := http.NewRequest("PUT", op.manifestURL(u, u.Tag), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	req.ContentLength = int64(len(body))
+
+	resp, err := op.authenticate(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("pushing manifest: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// extractLayout unpacks the .tar.gz at tarPath into dir.
+func extractLayout(tarPath, dir string) error {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, h.Name)
+		if !isWithinDir(dir, path) {
+			return fmt.Errorf("extracting %s: tar entry %q escapes destination directory", tarPath, h.Name)
+		}
+		if h.FileInfo().IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		out.Close()
+	}
+}
+
+// isWithinDir reports whether path, once cleaned, is dir itself or a
+// descendant of it, rejecting the absolute paths and ".." segments a
+// crafted tar entry could use to escape dir.
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// readIndex reads and decodes the "index.json" of an extracted image
+// layout directory.
+func readIndex(layoutDir string) (*ociIndex, error) {
+	b, err := ioutil.ReadFile(filepath.Join(layoutDir, "index.json"))
+	if err != nil {
+		return nil, fmt.Errorf("reading index.json: %v", err)
+	}
+
+	var index ociIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("decoding index.json: %v", err)
+	}
+
+	return &index, nil
+}
+
+// readBlob reads the blob identified by digest out of blobDir.
+func readBlob(blobDir, digest string) ([]byte, error) {
+	name, err := digestHex(digest)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filepath.Join(blobDir, name))
+}
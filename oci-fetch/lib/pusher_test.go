@@ -0,0 +1,40 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import "testing"
+
+func TestSameRegistryRepo(t *testing.T) {
+	tests := []struct {
+		name     string
+		fromRepo string
+		host     string
+		wantName string
+		wantOK   bool
+	}{
+		{"same registry", "registry.example.com/library/busybox", "registry.example.com", "library/busybox", true},
+		{"different registry", "other.example.com/library/busybox", "registry.example.com", "", false},
+		{"unrecorded source", "", "registry.example.com", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, ok := sameRegistryRepo(tt.fromRepo, tt.host)
+			if name != tt.wantName || ok != tt.wantOK {
+				t.Errorf("sameRegistryRepo(%q, %q) = (%q, %v), want (%q, %v)", tt.fromRepo, tt.host, name, ok, tt.wantName, tt.wantOK)
+			}
+		})
+	}
+}
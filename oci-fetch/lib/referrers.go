@@ -0,0 +1,211 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SetWithReferrers tells Fetch to also pull the artifacts (signatures,
+// SBOMs, attestations, ...) that reference the fetched image manifest,
+// writing their blobs into the image layout's blobs directory and listing
+// them in a "referrers/index.json".
+func (of *OCIFetcher) SetWithReferrers(withReferrers bool) {
+	of.withReferrers = withReferrers
+}
+
+// FetchReferrers discovers the artifacts that reference the image fetched
+// by the most recent call to Fetch, via the distribution-spec v1.1
+// referrers API (https://<host>/v2/<name>/referrers/<digest>), optionally
+// filtered to a single artifactType. Registries that don't implement the
+// referrers API are queried using the older tag-schema convention
+// instead ("sha256-<digest>.sig"-style tags discovered via tags/list);
+// artifactType is not applied to that fallback, which returns every
+// matching tag.
+func (of *OCIFetcher) FetchReferrers(u *URL, artifactType string) ([]Descriptor, error) {
+	descriptors, ok, err := of.fetchReferrersIndex(u, of.manifestDigest, artifactType)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return descriptors, nil
+	}
+
+	of.debugf("registry does not implement the referrers API, falling back to tag schema")
+	return of.fetchReferrersByTagSchema(u, of.manifestDigest)
+}
+
+// fetchReferrersIndex requests the referrers index for subjectDigest. ok
+// is false if the registry responded 404, signaling that the tag-schema
+// fallback should be used instead.
+func (of *OCIFetcher) fetchReferrersIndex(u *URL, subjectDigest, artifactType string) (descriptors []Descriptor, ok bool, err error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/referrers/%s", of.registryScheme(), u.Host, u.Name, subjectDigest)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", mediaTypeOCIIndex)
+	if artifactType != "" {
+		q := req.URL.Query()
+		q.Set("artifactType", artifactType)
+		req.URL.RawQuery = q.Encode()
+	}
+
+	of.debugf("GET %s", req.URL)
+
+	resp, err := of.authenticate(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, fmt.Errorf("fetching referrers for %s: %s", subjectDigest, resp.Status)
+	}
+
+	var index ociIndex
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, false, fmt.Errorf("decoding referrers index: %v", err)
+	}
+
+	return index.Manifests, true, nil
+}
+
+// fetchReferrersByTagSchema discovers referrers the pre-v1.1 way: listing
+// tags and matching the "sha256-<digest-hex>[.<suffix>]" naming
+// convention used by tools like cosign before the referrers API existed.
+func (of *OCIFetcher) fetchReferrersByTagSchema(u *URL, subjectDigest string) ([]Descriptor, error) {
+	hex, err := digestHex(subjectDigest)
+	if err != nil {
+		return nil, err
+	}
+	prefix := "sha256-" + hex
+
+	tags, err := of.listTags(u)
+	if err != nil {
+		return nil, fmt.Errorf("listing tags: %v", err)
+	}
+
+	var descriptors []Descriptor
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+
+		digest, body, err := of.fetchManifestBlob(u, tag, "")
+		if err != nil {
+			return nil, fmt.Errorf("fetching referrer %s: %v", tag, err)
+		}
+
+		var m Manifest
+		if err := json.Unmarshal(body, &m); err != nil {
+			return nil, fmt.Errorf("decoding referrer %s: %v", tag, err)
+		}
+
+		descriptors = append(descriptors, Descriptor{
+			MediaType: m.MediaType,
+			Digest:    digest,
+			Size:      int64(len(body)),
+		})
+	}
+
+	return descriptors, nil
+}
+
+// listTags returns the tags known for u's repository.
+func (of *OCIFetcher) listTags(u *URL) ([]string, error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/tags/list", of.registryScheme(), u.Host, u.Name)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	of.debugf("GET %s", req.URL)
+
+	resp, err := of.authenticate(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+
+	var result struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decoding tags list: %v", err)
+	}
+
+	return result.Tags, nil
+}
+
+// fetchAndWriteReferrers discovers the referrers of the just-fetched
+// image, pulls each one's manifest and blobs into blobDir, and records
+// them under dir/referrers/.
+func (of *OCIFetcher) fetchAndWriteReferrers(u *URL, dir, blobDir string) error {
+	descriptors, err := of.FetchReferrers(u, "")
+	if err != nil {
+		return err
+	}
+
+	referrersDir := filepath.Join(dir, "referrers")
+	if err := os.MkdirAll(referrersDir, 0755); err != nil {
+		return err
+	}
+
+	for _, desc := range descriptors {
+		digest, body, err := of.fetchManifestBlob(u, desc.Digest, "")
+		if err != nil {
+			return fmt.Errorf("fetching referrer %s: %v", desc.Digest, err)
+		}
+		if err := writeBlob(blobDir, digest, body); err != nil {
+			return err
+		}
+
+		var m Manifest
+		if err := json.Unmarshal(body, &m); err != nil {
+			return fmt.Errorf("decoding referrer %s: %v", digest, err)
+		}
+		if err := of.fetchBlobCached(u, m.Config.Digest, m.Config.Size, blobDir); err != nil {
+			return fmt.Errorf("fetching referrer %s config: %v", digest, err)
+		}
+		for _, layer := range m.Layers {
+			if err := of.fetchBlobCached(u, layer.Digest, layer.Size, blobDir); err != nil {
+				return fmt.Errorf("fetching referrer %s layer %s: %v", digest, layer.Digest, err)
+			}
+		}
+	}
+
+	index, err := json.Marshal(ociIndex{SchemaVersion: 2, Manifests: descriptors})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(referrersDir, "index.json"), index, 0644)
+}
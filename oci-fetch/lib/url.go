@@ -0,0 +1,60 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"fmt"
+	"strings"
+)
+
+const defaultTag = "latest"
+
+// URL represents a parsed "docker://HOST/NAME[:TAG]" image reference.
+type URL struct {
+	Host string
+	Name string
+	Tag  string
+}
+
+// NewURL parses a "docker://HOST/NAME[:TAG]" reference into a URL.
+func NewURL(s string) (*URL, error) {
+	const scheme = "docker://"
+
+	if !strings.HasPrefix(s, scheme) {
+		return nil, fmt.Errorf("unsupported reference %q: expected a %q reference", s, scheme)
+	}
+
+	rest := strings.TrimPrefix(s, scheme)
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid reference %q: expected %sHOST/NAME[:TAG]", s, scheme)
+	}
+
+	host := parts[0]
+	name := parts[1]
+	tag := defaultTag
+
+	if idx := strings.LastIndex(name, ":"); idx != -1 && !strings.Contains(name[idx:], "/") {
+		tag = name[idx+1:]
+		name = name[:idx]
+	}
+
+	return &URL{Host: host, Name: name, Tag: tag}, nil
+}
+
+// String returns the canonical "docker://HOST/NAME:TAG" form of the URL.
+func (u *URL) String() string {
+	return fmt.Sprintf("docker://%s/%s:%s", u.Host, u.Name, u.Tag)
+}
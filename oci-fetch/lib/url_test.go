@@ -0,0 +1,66 @@
+// Copyright 2016 The Linux Foundation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import "testing"
+
+func TestNewURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		ref      string
+		wantHost string
+		wantName string
+		wantTag  string
+	}{
+		{"tagged", "docker://registry.example.com/library/busybox:1.36", "registry.example.com", "library/busybox", "1.36"},
+		{"default tag", "docker://registry.example.com/library/busybox", "registry.example.com", "library/busybox", defaultTag},
+		{"port in host", "docker://localhost:5000/myimage:latest", "localhost:5000", "myimage", "latest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := NewURL(tt.ref)
+			if err != nil {
+				t.Fatalf("NewURL(%q) returned error: %v", tt.ref, err)
+			}
+			if u.Host != tt.wantHost || u.Name != tt.wantName || u.Tag != tt.wantTag {
+				t.Errorf("NewURL(%q) = %+v, want {%s %s %s}", tt.ref, u, tt.wantHost, tt.wantName, tt.wantTag)
+			}
+		})
+	}
+}
+
+func TestNewURLInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"registry.example.com/name",
+		"docker://registry.example.com",
+		"docker://registry.example.com/",
+	}
+
+	for _, ref := range tests {
+		if _, err := NewURL(ref); err == nil {
+			t.Errorf("NewURL(%q) returned nil error, want one", ref)
+		}
+	}
+}
+
+func TestURLString(t *testing.T) {
+	u := &URL{Host: "registry.example.com", Name: "library/busybox", Tag: "1.36"}
+	want := "docker://registry.example.com/library/busybox:1.36"
+	if got := u.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
@@ -36,10 +36,17 @@ import (
 var (
 	username                        string
 	password                        string
+	authfile                        string
+	cacheDir                        string
 	flagPromptCredentials           bool
 	flagDebug                       bool
 	flagInsecureAllowHTTP           bool
 	flagInsecureSkipTLSVerification bool
+	flagWithReferrers               bool
+	flagMaxParallelDownloads        int
+	flagProgress                    string
+	flagPlatform                    string
+	flagAllPlatforms                bool
 	cmdOCIFetch                     = &cobra.Command{
 		Use:     "oci-fetch docker://HOST/IMAGENAME[:TAG] FILEPATH",
 		Short:   "an OCI image fetcher",
@@ -47,15 +54,31 @@ var (
 		Example: "oci-fetch docker://registry-1.docker.io/library/nginx:latest nginx.oci",
 		Run:     runOCIFetch,
 	}
+	cmdPush = &cobra.Command{
+		Use:     "push FILEPATH docker://HOST/IMAGENAME[:TAG]",
+		Short:   "push a .tar.gz produced by oci-fetch to an OCI/Docker registry",
+		Long:    "oci-fetch push uploads the blobs, manifest, and tag of a local OCI image layout .tar.gz to a v2 registry",
+		Example: "oci-fetch push nginx.oci docker://registry-1.docker.io/myuser/nginx:latest",
+		Run:     runPush,
+	}
 )
 
 func init() {
 	cmdOCIFetch.PersistentFlags().StringVar(&username, "username", "", "username for pull")
 	cmdOCIFetch.PersistentFlags().StringVar(&password, "password", "", "password for pull")
+	cmdOCIFetch.PersistentFlags().StringVar(&authfile, "authfile", "", "path to a Docker/Podman config.json to read credentials from (defaults to $REGISTRY_AUTH_FILE, $DOCKER_CONFIG/config.json, or ~/.docker/config.json)")
+	cmdOCIFetch.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to cache manifests and blobs in, to skip re-downloading unchanged images")
+	cmdOCIFetch.Flags().BoolVar(&flagWithReferrers, "with-referrers", false, "also fetch artifacts (signatures, SBOMs, attestations) that reference the image, into a referrers/ directory")
+	cmdOCIFetch.Flags().IntVar(&flagMaxParallelDownloads, "max-parallel-downloads", 4, "maximum number of layers to download at once")
+	cmdOCIFetch.Flags().StringVar(&flagProgress, "progress", "auto", "progress reporting: auto, plain, or none")
+	cmdOCIFetch.Flags().StringVar(&flagPlatform, "platform", "", "platform to select from a multi-arch image, as os/arch[/variant] (defaults to the host's own platform)")
+	cmdOCIFetch.Flags().BoolVar(&flagAllPlatforms, "all-platforms", false, "fetch every platform of a multi-arch image instead of selecting one")
 	cmdOCIFetch.PersistentFlags().BoolVar(&flagPromptCredentials, "prompt-credentials", false, "prompt for username and password for pull")
 	cmdOCIFetch.PersistentFlags().BoolVar(&flagDebug, "debug", false, "print out debugging information to stderr")
 	cmdOCIFetch.PersistentFlags().BoolVar(&flagInsecureAllowHTTP, "insecure-allow-http", false, "don't enforce encryption when fetching images")
 	cmdOCIFetch.PersistentFlags().BoolVar(&flagInsecureSkipTLSVerification, "insecure-skip-tls-verification", false, "don't perform TLS certificate verification")
+
+	cmdOCIFetch.AddCommand(cmdPush)
 }
 
 func main() {
@@ -96,6 +119,30 @@ func runOCIFetch(cmd *cobra.Command, args []string) {
 	}
 
 	of := lib.NewOCIFetcher(username, password, flagInsecureAllowHTTP, flagInsecureSkipTLSVerification, flagDebug)
+	if err := of.SetAuthfile(authfile); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if err := of.SetCacheDir(cacheDir); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	of.SetWithReferrers(flagWithReferrers)
+	of.SetMaxParallelDownloads(flagMaxParallelDownloads)
+	if err := of.SetProgress(lib.ProgressMode(flagProgress)); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if flagPlatform != "" {
+		platform, err := lib.ParsePlatform(flagPlatform)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		of.SetPlatform(platform)
+	}
+	of.SetAllPlatforms(flagAllPlatforms)
+
 	err = of.Fetch(u, tmpDir)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
@@ -122,6 +169,40 @@ func runOCIFetch(cmd *cobra.Command, args []string) {
 	}
 }
 
+func runPush(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Print(cmd.UsageString())
+		os.Exit(1)
+	}
+
+	tarPath := args[0]
+
+	u, err := lib.NewURL(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if flagPromptCredentials {
+		err = readCredentials(&username, &password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	op := lib.NewOCIPusher(username, password, flagInsecureAllowHTTP, flagInsecureSkipTLSVerification, flagDebug)
+	if err := op.SetAuthfile(authfile); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if err := op.Push(tarPath, u); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
 func readCredentials(username, password *string) error {
 	reader := bufio.NewReader(os.Stdin)
 